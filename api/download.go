@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2020 Jecoz
+//
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// downloadChunkSize is the buffer size TeeReader reads are split into,
+// so each chunk becomes visible to concurrent readers (via the
+// FileHandler's download tracker) as soon as it lands on disk, instead
+// of only once the whole body has been copied.
+const downloadChunkSize = 32 * 1024
+
+// Downloader fetches images in a bounded worker pool independent of
+// whatever is driving it (a CSV reader, in practice), so a slow or
+// large image no longer blocks the next record from being processed.
+type Downloader struct {
+	fh  *FileHandler
+	sem chan struct{}
+
+	budget struct {
+		sync.Mutex
+		limited   bool
+		remaining int64
+	}
+}
+
+// NewDownloader returns a Downloader that runs at most workers fetches
+// concurrently (workers <= 0 picks 1) and caps the bytes reserved
+// across in-flight downloads at once at byteBudget (byteBudget <= 0
+// means unlimited).
+func NewDownloader(fh *FileHandler, workers int, byteBudget int64) *Downloader {
+	if workers <= 0 {
+		workers = 1
+	}
+	d := &Downloader{
+		fh:  fh,
+		sem: make(chan struct{}, workers),
+	}
+	d.budget.limited = byteBudget > 0
+	d.budget.remaining = byteBudget
+	return d
+}
+
+// reserve deducts n bytes from the remaining budget, refusing if that
+// would push it negative. An unlimited budget always succeeds.
+func (d *Downloader) reserve(n int64) bool {
+	d.budget.Lock()
+	defer d.budget.Unlock()
+	if !d.budget.limited {
+		return true
+	}
+	if n > d.budget.remaining {
+		return false
+	}
+	d.budget.remaining -= n
+	return true
+}
+
+// release returns n previously reserved bytes to the budget, once
+// they're no longer part of an in-flight download.
+func (d *Downloader) release(n int64) {
+	d.budget.Lock()
+	defer d.budget.Unlock()
+	if !d.budget.limited {
+		return
+	}
+	d.budget.remaining += n
+}
+
+// Fetch downloads link into path in the background, calling onHeaders
+// as soon as the response headers arrive (with the advertised content
+// length, or -1 if unknown) so the caller can broadcast the DI well
+// before the body has finished landing on disk. It blocks only long
+// enough to acquire a worker slot.
+func (d *Downloader) Fetch(link, path string, onHeaders func(bytesTotal int64)) {
+	d.sem <- struct{}{}
+	go func() {
+		defer func() { <-d.sem }()
+		if err := d.fetch(link, path, onHeaders); err != nil {
+			errorf("unable to download %v: %v", link, err)
+		}
+	}()
+}
+
+func (d *Downloader) fetch(link, path string, onHeaders func(bytesTotal int64)) error {
+	resp, err := http.Get(link)
+	if err != nil {
+		return fmt.Errorf("unable to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	total := resp.ContentLength
+
+	file, dl, err := d.fh.beginDownload(path, total)
+	if err != nil {
+		return fmt.Errorf("unable to prepare file for storing image: %w", err)
+	}
+	defer file.Close()
+	defer d.fh.endDownload(path)
+
+	onHeaders(total)
+
+	// Reserve budget per chunk as bytes actually land, rather than all
+	// at once against the advertised Content-Length: that length may be
+	// absent (chunked transfer), and reserving incrementally lets
+	// release give the budget back once the download is no longer
+	// in-flight instead of holding it for the process lifetime.
+	var reserved int64
+	defer func() {
+		if reserved > 0 {
+			d.release(reserved)
+		}
+	}()
+
+	buf := make([]byte, downloadChunkSize)
+	r := io.TeeReader(resp.Body, file)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if !d.reserve(int64(n)) {
+				dl.finish(fmt.Errorf("download exceeded byte budget"))
+				return fmt.Errorf("download of %v exceeded byte budget, aborted after %d bytes", path, reserved)
+			}
+			reserved += int64(n)
+			dl.addWritten(int64(n))
+		}
+		if err == io.EOF {
+			dl.finish(nil)
+			if ferr := file.finalize(); ferr != nil {
+				errorf("unable to remove download metadata for %v: %v", path, ferr)
+			}
+			return nil
+		}
+		if err != nil {
+			dl.finish(err)
+			return fmt.Errorf("unable to store image: %w", err)
+		}
+	}
+}
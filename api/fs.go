@@ -5,44 +5,252 @@
 package api
 
 import (
-	"log"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 )
 
-var arg0 = filepath.Base(os.Args[0])
+// download tracks a single in-flight write to disk, letting concurrent
+// readers (HTTP range requests in particular) block until the bytes
+// they asked for have actually landed instead of racing a half-written
+// file.
+type download struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	written int64
+	total   int64 // advertised size, or -1 if unknown
+	done    bool
+	err     error
+}
+
+func newDownload(total int64) *download {
+	d := &download{total: total}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+func (d *download) addWritten(n int64) {
+	d.mu.Lock()
+	d.written += n
+	d.cond.Broadcast()
+	d.mu.Unlock()
+}
+
+func (d *download) finish(err error) {
+	d.mu.Lock()
+	d.done = true
+	d.err = err
+	d.cond.Broadcast()
+	d.mu.Unlock()
+}
 
-func logf(format string, args ...interface{}) {
-	log.Printf(arg0+" * "+format, args...)
+// waitFor blocks until at least upto bytes have been written, or the
+// download finishes, whichever comes first. upto < 0 waits for the
+// download to finish outright. It returns how many bytes are available
+// to read and whether the download is done.
+func (d *download) waitFor(upto int64) (written int64, done bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for !d.done && (upto < 0 || d.written < upto) {
+		d.cond.Wait()
+	}
+	return d.written, d.done, d.err
 }
 
 type FileHandler struct {
-	fs  http.Handler
-	dir string
+	fs     http.Handler
+	dir    string
+	signer *Signer
+
+	downloads struct {
+		sync.Mutex
+		m map[string]*download
+	}
+
+	oneShot struct {
+		sync.Mutex
+	}
 }
 
-func NewFileHandler(d string) *FileHandler {
+// NewFileHandler serves the files under d. signer, if non-nil, requires
+// every request to carry a valid signed URL (see Signer.SignedURL) or a
+// one-shot token (see NewOneShotToken); a nil signer leaves d
+// completely open, as before.
+func NewFileHandler(d string, signer *Signer) *FileHandler {
 	return &FileHandler{
-		fs:  http.FileServer(http.Dir(d)),
-		dir: d,
+		fs:     http.FileServer(http.Dir(d)),
+		dir:    d,
+		signer: signer,
 	}
 }
 
 func (f *FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	logf("file request from %v: %v", r.RemoteAddr, r.URL.Path)
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+
+	if token := r.URL.Query().Get("token"); token != "" {
+		bound, ok := f.consumeOneShotToken(token)
+		if !ok || bound != path {
+			http.Error(w, "invalid or already used token", http.StatusForbidden)
+			return
+		}
+	} else if f.signer != nil {
+		if err := f.signer.verify(path, r, OpRead); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	if dl := f.downloadFor(path); dl != nil {
+		f.servePartial(w, r, path, dl)
+		return
+	}
 	f.fs.ServeHTTP(w, r)
 }
 
+// servePartial serves path while a Downloader is still writing it to
+// disk, blocking on dl until the requested byte range (or the whole
+// file, for a plain GET) is available.
+func (f *FileHandler) servePartial(w http.ResponseWriter, r *http.Request, path string, dl *download) {
+	start, end, ranged := int64(0), int64(-1), false
+	if h := r.Header.Get("Range"); h != "" {
+		var ok bool
+		start, end, ok = parseRange(h)
+		if !ok {
+			http.Error(w, "invalid range", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		ranged = true
+	}
+
+	upto := int64(-1)
+	if end >= 0 {
+		upto = end + 1
+	}
+	written, done, err := dl.waitFor(upto)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ranged && start >= written {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", written))
+		http.Error(w, "range start exceeds content length", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if end < 0 || end >= written {
+		end = written - 1
+	}
+
+	file, err := os.Open(filepath.Join(f.dir, path))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	if ranged {
+		total := "*"
+		if done {
+			total = strconv.FormatInt(written, 10)
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", start, end, total))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	io.CopyN(w, io.NewSectionReader(file, start, end-start+1), end-start+1)
+}
+
+// parseRange parses a single-range "bytes=start-end" Range header
+// value. end is -1 for an open-ended range ("bytes=N-"). ok is false if
+// h isn't a simple single range.
+func parseRange(h string) (start, end int64, ok bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(h, prefix) {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(h, prefix), "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	s, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if parts[1] == "" {
+		return s, -1, true
+	}
+	e, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return s, e, true
+}
+
+// Exists also recognizes a file that is still being downloaded, so
+// callers don't kick off a second, redundant download for it.
 func (f *FileHandler) Exists(path string) bool {
+	if f.downloadFor(path) != nil {
+		return true
+	}
 	_, err := os.Stat(filepath.Join(f.dir, path))
 	return err == nil
 }
 
-func (f *FileHandler) Create(path string) (*os.File, error) {
+// Create opens path for writing, handing out a *ChunkedFile that tracks
+// which byte ranges have landed via a sidecar .meta file, so a
+// resumable upload (ServeUpload) can pick up an interrupted transfer.
+// Callers that don't care about resumability (e.g. Downloader) can use
+// it exactly like an *os.File.
+func (f *FileHandler) Create(path string) (*ChunkedFile, error) {
 	fn := filepath.Join(f.dir, path)
 	if err := os.MkdirAll(filepath.Dir(fn), os.ModePerm); err != nil {
 		return nil, err
 	}
-	return os.Create(fn)
+	return openChunkedFile(fn, fn+".meta")
+}
+
+// beginDownload creates path for writing and registers an in-flight
+// tracker for it, so concurrent requests can block on the tracker
+// instead of racing the half-written file. total is the expected size
+// in bytes, or -1 if unknown.
+func (f *FileHandler) beginDownload(path string, total int64) (*ChunkedFile, *download, error) {
+	file, err := f.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := file.setSize(total); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	dl := newDownload(total)
+	f.downloads.Lock()
+	if f.downloads.m == nil {
+		f.downloads.m = make(map[string]*download)
+	}
+	f.downloads.m[path] = dl
+	f.downloads.Unlock()
+
+	return file, dl, nil
+}
+
+// endDownload unregisters path's in-flight tracker once the download
+// has finished, successfully or not, so later requests fall back to
+// serving it as an ordinary static file.
+func (f *FileHandler) endDownload(path string) {
+	f.downloads.Lock()
+	delete(f.downloads.m, path)
+	f.downloads.Unlock()
+}
+
+func (f *FileHandler) downloadFor(path string) *download {
+	f.downloads.Lock()
+	defer f.downloads.Unlock()
+	return f.downloads.m[path]
 }
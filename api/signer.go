@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2020 Jecoz
+//
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Op is a single operation a signed URL can authorize.
+type Op byte
+
+const (
+	OpRead  Op = 'r'
+	OpWrite Op = 'w'
+)
+
+// Signer authorizes FileHandler requests with expiring, HMAC-signed
+// URLs, modeled on transfer.sh's tokens. A FileHandler with a nil
+// Signer (the default) stays fully open, as before.
+type Signer struct {
+	key   []byte
+	clock func() time.Time
+}
+
+// NewSigner builds a Signer around key. key authorizes every path it
+// signs for, so it must be kept secret.
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key, clock: time.Now}
+}
+
+// SignedURL returns path with "exp", "ops" and "sig" query parameters
+// appended, granting ops on path until ttl elapses. path is signed in
+// its canonical form (see canonicalSignedPath), the same one
+// ServeHTTP/ServeUpload verify against, so a caller can pass it with
+// or without a leading slash and still have the resulting URL verify.
+func (s *Signer) SignedURL(path string, ttl time.Duration, ops ...Op) string {
+	exp := s.clock().Add(ttl).Unix()
+	opstr := opsString(ops)
+	return fmt.Sprintf("%s?exp=%d&ops=%s&sig=%s", path, exp, opstr, s.sign(canonicalSignedPath(path), exp, opstr))
+}
+
+// canonicalSignedPath normalizes path to the form FileHandler verifies
+// signatures against: r.URL.Path with its leading slash stripped.
+func canonicalSignedPath(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+func opsString(ops []Op) string {
+	seen := make(map[Op]bool, len(ops))
+	var b strings.Builder
+	for _, op := range ops {
+		if seen[op] {
+			continue
+		}
+		seen[op] = true
+		b.WriteByte(byte(op))
+	}
+	return b.String()
+}
+
+func (s *Signer) sign(path string, exp int64, ops string) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s:%d:%s", path, exp, ops)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify reports whether r carries a still-valid signature for path
+// granting op.
+func (s *Signer) verify(path string, r *http.Request, op Op) error {
+	q := r.URL.Query()
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid exp parameter")
+	}
+	ops := q.Get("ops")
+	if !hmac.Equal([]byte(q.Get("sig")), []byte(s.sign(canonicalSignedPath(path), exp, ops))) {
+		return fmt.Errorf("invalid signature")
+	}
+	if s.clock().Unix() > exp {
+		return fmt.Errorf("url has expired")
+	}
+	if !strings.ContainsRune(ops, rune(op)) {
+		return fmt.Errorf("url does not grant %q", string(rune(op)))
+	}
+	return nil
+}
+
+// randomToken returns a random hex-encoded token, used for one-shot
+// download URLs.
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// oneShotIndexPath is where NewOneShotToken persists its path->token
+// index, alongside the files FileHandler serves.
+func (f *FileHandler) oneShotIndexPath() string {
+	return filepath.Join(f.dir, ".oneshot-tokens.json")
+}
+
+func (f *FileHandler) loadOneShotTokens() (map[string]string, error) {
+	m := make(map[string]string)
+	b, err := os.ReadFile(f.oneShotIndexPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("unable to parse one-shot token index: %w", err)
+	}
+	return m, nil
+}
+
+func (f *FileHandler) saveOneShotTokens(m map[string]string) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.oneShotIndexPath(), b, 0644)
+}
+
+// NewOneShotToken mints a token that grants exactly one GET of path
+// before self-destructing. It is recorded in an on-disk index, so it
+// survives a restart until consumed, letting an untrusted collaborator
+// be handed a URL ("path?token=...") without needing a Signer.
+func (f *FileHandler) NewOneShotToken(path string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	f.oneShot.Lock()
+	defer f.oneShot.Unlock()
+
+	m, err := f.loadOneShotTokens()
+	if err != nil {
+		return "", err
+	}
+	m[token] = path
+	if err := f.saveOneShotTokens(m); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeOneShotToken removes token from the index and reports the
+// path it was bound to. Only the first caller for a given token gets
+// ok == true; every later one finds it already gone.
+func (f *FileHandler) consumeOneShotToken(token string) (path string, ok bool) {
+	f.oneShot.Lock()
+	defer f.oneShot.Unlock()
+
+	m, err := f.loadOneShotTokens()
+	if err != nil {
+		errorf("unable to read one-shot token index: %v", err)
+		return "", false
+	}
+	path, ok = m[token]
+	if !ok {
+		return "", false
+	}
+	delete(m, token)
+	if err := f.saveOneShotTokens(m); err != nil {
+		errorf("unable to persist one-shot token index: %v", err)
+	}
+	return path, true
+}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2020 Jecoz
+//
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSigner(now time.Time) *Signer {
+	s := NewSigner([]byte("test-key"))
+	s.clock = func() time.Time { return now }
+	return s
+}
+
+func verifyRequest(rawURL string, at time.Time, path string, op Op) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		panic(err)
+	}
+	s := newTestSigner(at)
+	r := &http.Request{URL: u}
+	return s.verify(path, r, op)
+}
+
+// TestSignerSignedURLVerifiesThroughServeHTTP exercises SignedURL and
+// verify the way a real client does: FileHandler.ServeHTTP sees
+// r.URL.Path with its leading slash stripped, so the URL SignedURL
+// hands out (built from a leading-slash path, as callers naturally
+// write it) must verify against that same stripped form.
+func TestSignerSignedURLVerifiesThroughServeHTTP(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	issued := time.Unix(1_700_000_000, 0)
+	s := newTestSigner(issued)
+	fh := NewFileHandler(dir, s)
+
+	signed := s.SignedURL("/a.jpg", time.Minute, OpRead)
+	s.clock = func() time.Time { return issued.Add(30 * time.Second) }
+
+	r := httptest.NewRequest(http.MethodGet, signed, nil)
+	w := httptest.NewRecorder()
+	fh.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q, want 200", w.Code, w.Body.String())
+	}
+	if w.Body.String() != "hello" {
+		t.Fatalf("body = %q, want %q", w.Body.String(), "hello")
+	}
+}
+
+func TestSignerVerifyRejectsExpired(t *testing.T) {
+	issued := time.Unix(1_700_000_000, 0)
+	s := newTestSigner(issued)
+	signed := s.SignedURL("/images/a.jpg", time.Minute, OpRead)
+
+	if err := verifyRequest(signed, issued.Add(2*time.Minute), "/images/a.jpg", OpRead); err == nil {
+		t.Fatalf("verify after ttl elapsed: want error, got nil")
+	}
+}
+
+func TestSignerVerifyRejectsWrongOp(t *testing.T) {
+	issued := time.Unix(1_700_000_000, 0)
+	s := newTestSigner(issued)
+	signed := s.SignedURL("/images/a.jpg", time.Minute, OpRead)
+
+	if err := verifyRequest(signed, issued, "/images/a.jpg", OpWrite); err == nil {
+		t.Fatalf("verify with op not granted: want error, got nil")
+	}
+}
+
+func TestSignerVerifyRejectsTamperedPath(t *testing.T) {
+	issued := time.Unix(1_700_000_000, 0)
+	s := newTestSigner(issued)
+	signed := s.SignedURL("/images/a.jpg", time.Minute, OpRead)
+
+	if err := verifyRequest(signed, issued, "/images/b.jpg", OpRead); err == nil {
+		t.Fatalf("verify against a different path: want error, got nil")
+	}
+}
+
+func TestFileHandlerOneShotTokenConsumedOnce(t *testing.T) {
+	f := NewFileHandler(t.TempDir(), nil)
+
+	token, err := f.NewOneShotToken("images/a.jpg")
+	if err != nil {
+		t.Fatalf("NewOneShotToken: %v", err)
+	}
+
+	path, ok := f.consumeOneShotToken(token)
+	if !ok || path != "images/a.jpg" {
+		t.Fatalf("consumeOneShotToken first call = (%q, %v), want (images/a.jpg, true)", path, ok)
+	}
+
+	if _, ok := f.consumeOneShotToken(token); ok {
+		t.Fatalf("consumeOneShotToken second call: want ok = false, already consumed")
+	}
+}
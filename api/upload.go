@@ -0,0 +1,311 @@
+// SPDX-FileCopyrightText: 2020 Jecoz
+//
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// uploadChunkSize is the buffer size a multipart.Part is read in. Many
+// clients split a single part across several underlying writes, so a
+// chunk is copied by reading repeatedly until the expected number of
+// bytes has landed, not with a single Read call.
+const uploadChunkSize = 32 * 1024
+
+// byteRange is a half-open [Start, End) span of bytes already received
+// by a ChunkedFile.
+type byteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// ChunkedFile wraps the destination file for an upload, tracking which
+// byte ranges have landed in a JSON ".meta" sidecar next to it, so an
+// upload interrupted mid-transfer can be resumed instead of restarted
+// from scratch. Only the exported Size/Ranges fields are persisted; a
+// caller that doesn't care about resumability (e.g. Downloader) can use
+// a ChunkedFile exactly like an *os.File via Write/Close.
+type ChunkedFile struct {
+	mu   sync.Mutex
+	f    *os.File
+	meta string
+	off  int64
+
+	Size   int64       `json:"size"` // expected total size, -1 if unknown
+	Ranges []byteRange `json:"ranges"`
+}
+
+// openChunkedFile opens (or creates) path for writing and loads any
+// ranges already recorded in metaPath from a previous, interrupted
+// attempt.
+func openChunkedFile(path, metaPath string) (*ChunkedFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	cf := &ChunkedFile{f: file, meta: metaPath, Size: -1}
+	if b, err := os.ReadFile(metaPath); err == nil {
+		if err := json.Unmarshal(b, cf); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("unable to parse upload metadata: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		file.Close()
+		return nil, err
+	}
+	return cf, nil
+}
+
+func (c *ChunkedFile) saveMeta() error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.meta, b, 0644)
+}
+
+// setSize records the upload's expected total size, so Done can tell
+// when every byte has been received.
+func (c *ChunkedFile) setSize(n int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Size = n
+	return c.saveMeta()
+}
+
+// Write implements io.Writer, appending sequentially from wherever the
+// last Write or WriteAt left off.
+func (c *ChunkedFile) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	off := c.off
+	c.mu.Unlock()
+	return c.WriteAt(p, off)
+}
+
+// WriteAt writes p at byte offset off and merges [off, off+len(p)) into
+// the set of received ranges, persisting them to the sidecar .meta
+// file. It advances the sequential Write cursor too, so Write and
+// WriteAt can be mixed freely.
+func (c *ChunkedFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := c.f.WriteAt(p, off)
+	if n > 0 {
+		c.mu.Lock()
+		c.addRange(off, off+int64(n))
+		c.off = off + int64(n)
+		if merr := c.saveMeta(); err == nil {
+			err = merr
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// addRange merges [start, end) into c.Ranges, coalescing overlapping or
+// touching ranges. Callers must hold c.mu.
+func (c *ChunkedFile) addRange(start, end int64) {
+	ranges := append(c.Ranges, byteRange{Start: start, End: end})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if len(merged) > 0 && r.Start <= merged[len(merged)-1].End {
+			if r.End > merged[len(merged)-1].End {
+				merged[len(merged)-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	c.Ranges = merged
+}
+
+// Received returns how many bytes have landed so far, across every
+// received range regardless of whether they're contiguous from 0.
+func (c *ChunkedFile) Received() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int64
+	for _, r := range c.Ranges {
+		n += r.End - r.Start
+	}
+	return n
+}
+
+// ReceivedPrefix returns how many bytes starting at offset 0 have
+// landed without a gap, i.e. how far a client can safely resume a
+// sequential upload from. Unlike Received, it ignores any ranges that
+// arrived out of order and haven't been bridged yet.
+func (c *ChunkedFile) ReceivedPrefix() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.Ranges) == 0 || c.Ranges[0].Start != 0 {
+		return 0
+	}
+	return c.Ranges[0].End
+}
+
+// Done reports whether every byte up to Size has been received.
+func (c *ChunkedFile) Done() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Size >= 0 && len(c.Ranges) == 1 && c.Ranges[0].Start == 0 && c.Ranges[0].End >= c.Size
+}
+
+func (c *ChunkedFile) Close() error {
+	return c.f.Close()
+}
+
+// finalize removes the .meta sidecar once the upload is complete (or
+// once a non-resumable writer like Downloader is done with it).
+// FileHandler.Exists and ServeHTTP do not consult the sidecar.
+func (c *ChunkedFile) finalize() error {
+	err := os.Remove(c.meta)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// parseContentRange parses a "Content-Range: bytes X-Y/Z" header as
+// sent by a resumable upload client for a single chunk.
+func parseContentRange(h string) (start, end, total int64, ok bool) {
+	const prefix = "bytes "
+	if !strings.HasPrefix(h, prefix) {
+		return 0, 0, 0, false
+	}
+	rest := strings.TrimPrefix(h, prefix)
+	slash := strings.IndexByte(rest, '/')
+	if slash < 0 {
+		return 0, 0, 0, false
+	}
+	rangePart, totalPart := rest[:slash], rest[slash+1:]
+
+	dash := strings.IndexByte(rangePart, '-')
+	if dash < 0 {
+		return 0, 0, 0, false
+	}
+	s, err := strconv.ParseInt(rangePart[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	e, err := strconv.ParseInt(rangePart[dash+1:], 10, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	t, err := strconv.ParseInt(totalPart, 10, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return s, e, t, true
+}
+
+// writeChunk copies part's bytes into cf starting at byte offset start,
+// reading in bounded uploadChunkSize buffers until the expected number
+// of bytes (end-start+1) has been copied.
+func writeChunk(cf *ChunkedFile, part *multipart.Part, start, end int64) error {
+	expected := end - start + 1
+	buf := make([]byte, uploadChunkSize)
+	off, got := start, int64(0)
+	for got < expected {
+		n, err := part.Read(buf)
+		if n > 0 {
+			if _, werr := cf.WriteAt(buf[:n], off); werr != nil {
+				return fmt.Errorf("unable to write chunk: %w", werr)
+			}
+			off += int64(n)
+			got += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read chunk: %w", err)
+		}
+	}
+	return nil
+}
+
+// ServeUpload handles a single resumable upload chunk. The request
+// carries a "Content-Range: bytes X-Y/Z" header describing where this
+// chunk belongs in the final file (Z is the upload's total size) and a
+// multipart/form-data body whose first part is the chunk's payload. It
+// streams the part straight to that byte offset, and responds with a
+// 308 Resume Incomplete plus, once any bytes from byte 0 onward have
+// landed, a Range header reporting that contiguous prefix, letting the
+// client resume an interrupted upload from that offset (no Range header
+// means resume from 0); once every byte is present it
+// responds 201 Created and drops the .meta sidecar.
+func (f *FileHandler) ServeUpload(w http.ResponseWriter, r *http.Request) {
+	logf("upload request from %v: %v", r.RemoteAddr, r.URL.Path)
+
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if f.signer != nil {
+		if err := f.signer.verify(path, r, OpWrite); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	start, end, total, ok := parseContentRange(r.Header.Get("Content-Range"))
+	if !ok {
+		http.Error(w, "missing or invalid Content-Range header", http.StatusBadRequest)
+		return
+	}
+
+	cf, err := f.Create(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cf.Close()
+
+	if err := cf.setSize(total); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	part, err := mr.NextPart()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := writeChunk(cf, part, start, end); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if cf.Done() {
+		if err := cf.finalize(); err != nil {
+			errorf("unable to remove upload metadata for %v: %v", path, err)
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	if prefix := cf.ReceivedPrefix(); prefix > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", prefix-1))
+	}
+	w.WriteHeader(308) // Resume Incomplete
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2020 Jecoz
+//
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestChunkedFile(t *testing.T) *ChunkedFile {
+	t.Helper()
+	dir := t.TempDir()
+	cf, err := openChunkedFile(filepath.Join(dir, "upload.bin"), filepath.Join(dir, "upload.bin.meta"))
+	if err != nil {
+		t.Fatalf("openChunkedFile: %v", err)
+	}
+	t.Cleanup(func() { cf.Close() })
+	return cf
+}
+
+func TestChunkedFileReceivedPrefixOutOfOrder(t *testing.T) {
+	cf := newTestChunkedFile(t)
+	if err := cf.setSize(30); err != nil {
+		t.Fatalf("setSize: %v", err)
+	}
+
+	// Second chunk arrives before the first: nothing contiguous from 0
+	// yet, even though Received() already counts 10 bytes.
+	if _, err := cf.WriteAt(make([]byte, 10), 20); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if got := cf.Received(); got != 10 {
+		t.Fatalf("Received() = %d, want 10", got)
+	}
+	if got := cf.ReceivedPrefix(); got != 0 {
+		t.Fatalf("ReceivedPrefix() = %d, want 0 (first chunk hasn't landed)", got)
+	}
+
+	// First chunk lands: now there's a contiguous prefix of 10 bytes,
+	// but a 10-byte gap (10-20) still separates it from the tail chunk.
+	if _, err := cf.WriteAt(make([]byte, 10), 0); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if got := cf.ReceivedPrefix(); got != 10 {
+		t.Fatalf("ReceivedPrefix() = %d, want 10", got)
+	}
+
+	// The gap closes: the whole file is now one contiguous range.
+	if _, err := cf.WriteAt(make([]byte, 10), 10); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if got := cf.ReceivedPrefix(); got != 30 {
+		t.Fatalf("ReceivedPrefix() = %d, want 30", got)
+	}
+	if !cf.Done() {
+		t.Fatalf("Done() = false, want true once every byte has landed")
+	}
+}
+
+func TestChunkedFileAddRangeCoalescesOverlaps(t *testing.T) {
+	cf := newTestChunkedFile(t)
+
+	cf.addRange(0, 10)
+	cf.addRange(20, 30)
+	cf.addRange(5, 25) // bridges and overlaps both existing ranges
+
+	if len(cf.Ranges) != 1 {
+		t.Fatalf("Ranges = %v, want a single merged range", cf.Ranges)
+	}
+	if cf.Ranges[0] != (byteRange{Start: 0, End: 30}) {
+		t.Fatalf("Ranges[0] = %+v, want {0 30}", cf.Ranges[0])
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	cases := []struct {
+		header            string
+		start, end, total int64
+		ok                bool
+	}{
+		{"bytes 0-9/100", 0, 9, 100, true},
+		{"bytes 10-19/*", 0, 0, 0, false},
+		{"not-a-range", 0, 0, 0, false},
+	}
+	for _, c := range cases {
+		start, end, total, ok := parseContentRange(c.header)
+		if ok != c.ok {
+			t.Errorf("parseContentRange(%q) ok = %v, want %v", c.header, ok, c.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if start != c.start || end != c.end || total != c.total {
+			t.Errorf("parseContentRange(%q) = (%d, %d, %d), want (%d, %d, %d)",
+				c.header, start, end, total, c.start, c.end, c.total)
+		}
+	}
+}
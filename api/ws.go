@@ -8,6 +8,7 @@ import (
 	"bufio"
 	"crypto/md5"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -18,6 +19,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -32,6 +34,10 @@ const (
 
 	// Time to wait before force close on connection.
 	closeGracePeriod = 10 * time.Second
+
+	// Send SSE keepalive comments to peers with this period, matching
+	// the websocket ping cadence used by api/ws.Client.
+	pingPeriod = 54 * time.Second
 )
 
 func logf(format string, args ...interface{}) {
@@ -49,9 +55,20 @@ func exitf(format string, args ...interface{}) {
 
 // DI is a DiscoursiveImage.
 type DI struct {
+	// ID is a stable, monotonically-increasing identifier assigned at
+	// ingest time (base36-encoded sequence number). It lets a client
+	// resume a subscription from a given point instead of only ever
+	// seeing the most recent item.
+	ID       string `json:"id"`
 	Link     string `json:"link"`
 	Word     string `json:"word"`
 	FileName string `json:"file_name"`
+
+	// BytesTotal is the Content-Length advertised by the image
+	// download, or -1 if the response advertised none. It is set once
+	// headers arrive, well before the file is fully written to disk; a
+	// DI whose file was already on disk carries 0.
+	BytesTotal int64 `json:"bytes_total,omitempty"`
 }
 
 type mapset struct {
@@ -85,6 +102,10 @@ type FileSystem interface {
 	Create(string) (*os.File, error)
 }
 
+// defaultBacklogSize is how many broadcast DIs NewStreamHandler keeps
+// around by default for subscribers to replay on connect.
+const defaultBacklogSize = 64
+
 type StreamHandler struct {
 	r       io.Reader
 	sd      string // storage directory path.
@@ -96,10 +117,13 @@ type StreamHandler struct {
 	m    *mapset
 	Done chan error
 	fs   FileSystem
+	dl   *Downloader
+	seq  uint64
 
-	lastDI struct {
+	backlog struct {
 		sync.Mutex
-		val *DI
+		size  int
+		items []*DI
 	}
 }
 
@@ -109,8 +133,14 @@ type diRx struct {
 }
 
 // NewStreamHandler returns a new http.Handler implementation that
-// supports websockets.
-func NewStreamHandler(in io.Reader, fs FileSystem, m *mapset) *StreamHandler {
+// supports websockets. dl drives image downloads in the background
+// instead of blocking the CSV read loop on them. backlogSize bounds how
+// many broadcast DIs are kept around for subscribers to replay on
+// connect or resume; 0 picks defaultBacklogSize.
+func NewStreamHandler(in io.Reader, fs FileSystem, dl *Downloader, m *mapset, backlogSize int) *StreamHandler {
+	if backlogSize <= 0 {
+		backlogSize = defaultBacklogSize
+	}
 	upgrader := websocket.Upgrader{
 		ReadBufferSize:    4096,
 		WriteBufferSize:   4096,
@@ -121,36 +151,78 @@ func NewStreamHandler(in io.Reader, fs FileSystem, m *mapset) *StreamHandler {
 	}
 	h := &StreamHandler{
 		fs:   fs,
+		dl:   dl,
 		r:    bufio.NewReader(in),
 		up:   upgrader,
 		m:    m,
 		Done: make(chan error, 1),
 	}
+	h.backlog.size = backlogSize
 	go h.Run()
 	return h
 }
 
+// recordBacklog appends di to the bounded replay backlog, evicting the
+// oldest entry once `size` is exceeded.
+func (h *StreamHandler) recordBacklog(di *DI) {
+	h.backlog.Lock()
+	defer h.backlog.Unlock()
+
+	h.backlog.items = append(h.backlog.items, di)
+	if len(h.backlog.items) > h.backlog.size {
+		h.backlog.items = h.backlog.items[len(h.backlog.items)-h.backlog.size:]
+	}
+}
+
+// backlogSince returns the backlog entries following the one with the
+// given id, oldest first. An empty id (or one no longer present in the
+// backlog) returns the whole backlog.
+func (h *StreamHandler) backlogSince(id string) []*DI {
+	h.backlog.Lock()
+	defer h.backlog.Unlock()
+
+	items := h.backlog.items
+	if id != "" {
+		for i, di := range items {
+			if di.ID == id {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+
+	out := make([]*DI, len(items))
+	copy(out, items)
+	return out
+}
+
 // OpenRx returns a new instance of a channel that is registered
-// with the stream handler. Each time a new image is read, it is
+// with the stream handler. It is seeded with the current replay
+// backlog, so a new subscriber catches up instead of only ever seeing
+// the next broadcast image. Each time a new image is read, it is
 // broadcasted to all registered channels.
 // Remember to call `close` when done with it, to allow the handler
 // to remove the channel from the list of registered clients.
 func (h *StreamHandler) OpenRx() *diRx {
-	c := make(chan *DI, 1)
+	return h.OpenRxSince("")
+}
 
-	// Inject last di processed to the new client.
-	h.lastDI.Lock()
-	// Inside the lock we'll get unique time values.
-	key := "stream:" + strconv.Itoa(int(time.Now().UnixNano()))
-	if di := h.lastDI.val; di != nil {
+// OpenRxSince is OpenRx, but seeds the returned channel with only the
+// backlog entries following sinceID instead of the whole backlog. An
+// empty sinceID behaves exactly like OpenRx.
+func (h *StreamHandler) OpenRxSince(sinceID string) *diRx {
+	backlog := h.backlogSince(sinceID)
+	c := make(chan *DI, len(backlog)+1)
+	for _, di := range backlog {
 		c <- di
 	}
-	h.lastDI.Unlock()
 
 	h.clients.Lock()
 	if h.clients.m == nil {
 		h.clients.m = make(map[string]chan *DI)
 	}
+	// Inside the lock we'll get unique time values.
+	key := "stream:" + strconv.Itoa(int(time.Now().UnixNano()))
 	// Remove the client if was already there.
 	if val, ok := h.clients.m[key]; ok {
 		close(val)
@@ -196,40 +268,23 @@ func decodeRecord(rec []string, m *mapset) (*DI, error) {
 	}, nil
 }
 
-func (h *StreamHandler) handleRecord(rec []string) (*DI, error) {
-	di, err := decodeRecord(rec, h.m)
-	if err != nil {
-		return nil, err
-	}
-
-	// If the file is already there, do not download again.
-	if h.fs.Exists(di.FileName) {
-		return di, nil
-	}
-
-	// Otherwise download it.
-	f, err := h.fs.Create(di.FileName)
-	if err != nil {
-		return nil, fmt.Errorf("unable to prepare file for storing image: %w", err)
-	}
-	defer f.Close()
-
-	logf("downloading image for: %v", di.FileName)
-
-	resp, err := http.Get(di.Link)
-	if err != nil {
-		return nil, fmt.Errorf("unable to download image: %w", err)
-	}
-	defer resp.Body.Close()
-	if _, err = io.Copy(f, resp.Body); err != nil {
-		return nil, fmt.Errorf("unable to store image: %w", err)
+// broadcastDI fans di out to every registered client and records it in
+// the replay backlog.
+func (h *StreamHandler) broadcastDI(di *DI) {
+	h.clients.Lock()
+	for _, v := range h.clients.m {
+		v <- di
 	}
+	h.clients.Unlock()
 
-	return di, nil
+	h.recordBacklog(di)
 }
 
 // Run keeps on reading from `h`'s internal reader, providing its
-// contents to the registered clients.
+// contents to the registered clients. Images not yet on disk are
+// downloaded in the background via h.dl: the DI is broadcast as soon as
+// the download's headers arrive, rather than once the file is fully
+// written, so the read loop never blocks on a slow or large image.
 func (h *StreamHandler) Run() {
 	logf("opening stream handler loop")
 	defer logf("closing stream handler loop")
@@ -246,25 +301,26 @@ func (h *StreamHandler) Run() {
 			h.Done <- fmt.Errorf("unable to read from input: %v", err)
 			return
 		}
-		di, err := h.handleRecord(rec)
+
+		di, err := decodeRecord(rec, h.m)
 		if err != nil {
 			errorf(err.Error())
 			continue
 		}
+		di.ID = strconv.FormatUint(atomic.AddUint64(&h.seq, 1), 36)
 
-		logf("---> %v", di.FileName)
-
-		// Send it to all clients.
-		h.clients.Lock()
-		for _, v := range h.clients.m {
-			v <- di
+		if h.fs.Exists(di.FileName) {
+			logf("---> %v", di.FileName)
+			h.broadcastDI(di)
+			continue
 		}
-		h.clients.Unlock()
 
-		// Save last di.
-		h.lastDI.Lock()
-		h.lastDI.val = di
-		h.lastDI.Unlock()
+		h.dl.Fetch(di.Link, di.FileName, func(bytesTotal int64) {
+			seeded := *di
+			seeded.BytesTotal = bytesTotal
+			logf("---> %v", seeded.FileName)
+			h.broadcastDI(&seeded)
+		})
 	}
 }
 
@@ -291,10 +347,110 @@ func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	rx := h.OpenRx()
 	defer rx.close()
 
+	var wsMu sync.Mutex
+	go h.readResumeRequests(ws, &wsMu)
+
 	for di := range rx.c {
-		if err := ws.WriteJSON(di); err != nil {
+		wsMu.Lock()
+		err := ws.WriteJSON(di)
+		wsMu.Unlock()
+		if err != nil {
 			wsError(ws, err)
 			return
 		}
 	}
 }
+
+// resumeRequest is the client-initiated counterpart of the SSE
+// Last-Event-ID header: a client that reconnected out of band (e.g.
+// after a network blip) can ask to be caught up from a known point
+// instead of replaying everything OpenRx already handed it.
+type resumeRequest struct {
+	Type    string `json:"type"`
+	SinceID string `json:"since_id"`
+}
+
+// readResumeRequests watches the websocket connection for resume
+// requests and replays the matching backlog slice directly, guarded by
+// wsMu since it writes to the same connection as ServeHTTP's main loop.
+func (h *StreamHandler) readResumeRequests(ws *websocket.Conn, wsMu *sync.Mutex) {
+	for {
+		var req resumeRequest
+		if err := ws.ReadJSON(&req); err != nil {
+			return
+		}
+		if req.Type != "resume" {
+			continue
+		}
+		for _, di := range h.backlogSince(req.SinceID) {
+			wsMu.Lock()
+			err := ws.WriteJSON(di)
+			wsMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeDI(w http.ResponseWriter, di *DI) error {
+	b, err := json.Marshal(di)
+	if err != nil {
+		return fmt.Errorf("unable to encode di: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: di\ndata: %s\n\n", di.ID, b)
+	return err
+}
+
+// ServeSSE is a first-class alternative to ServeHTTP for clients that
+// only want to read the stream: it reuses the same OpenRx fan-out but
+// emits text/event-stream frames instead of upgrading to a websocket,
+// which makes it trivially consumable from browsers and curl-based
+// tooling. A reconnecting client may set the Last-Event-ID header (as
+// the browser EventSource API does automatically) to receive whatever
+// is still held in the backlog instead of only the next DI.
+func (h *StreamHandler) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	logf("sse connection from %v, %v", r.RemoteAddr, r.URL)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// A client reconnecting with Last-Event-ID set only replays what it
+	// missed; everyone else gets the whole backlog, same as OpenRx.
+	rx := h.OpenRxSince(r.Header.Get("Last-Event-ID"))
+	defer rx.close()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case di, ok := <-rx.c:
+			if !ok {
+				return
+			}
+			if err := writeDI(w, di); err != nil {
+				errorf("sse: %v", err)
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				errorf("sse: %v", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -23,8 +23,9 @@ const (
 
 // Client is a middleman between the websocket connection and the hub.
 type Client struct {
-	Addr string
-	hub  *Hub
+	Addr  string
+	hub   *Hub
+	sched *Scheduler
 
 	// The websocket connection.
 	conn *websocket.Conn
@@ -32,12 +33,25 @@ type Client struct {
 	// Buffered channel of outbound messages.
 	send chan *DI
 
+	// Delivers backlog slices requested via a "resume" event, for
+	// forwardMessages to write out alongside live broadcasts.
+	resume chan []*DI
+
 	osc *osc.Client
 }
 
 type ClientEvent struct {
 	Type     string `json:"type"`
-	FileName string `json:"file_name"`
+	FileName string `json:"file_name,omitempty"`
+
+	// AtMs carries the media clock offset, in milliseconds, for an
+	// "anchor" event: the first client to send one seats the
+	// scheduler's anchor at that offset.
+	AtMs int64 `json:"at_ms,omitempty"`
+
+	// SinceID carries the last DI id a client saw, for a "resume"
+	// event: the hub replies with the backlog entries following it.
+	SinceID string `json:"since_id,omitempty"`
 }
 
 func wsError(ws *websocket.Conn, err error) {
@@ -75,6 +89,12 @@ func (c *Client) readMessages() {
 			msg.Append(event.FileName)
 		case "off-screen":
 			msg = osc.NewMessage("max/stop")
+		case "anchor":
+			c.sched.SetClientAnchor(time.Duration(event.AtMs) * time.Millisecond)
+			continue
+		case "resume":
+			c.resume <- c.hub.snapshotSince(event.SinceID)
+			continue
 		default:
 			wsError(c.conn, fmt.Errorf("undefined event type %v", event.Type))
 			continue
@@ -106,6 +126,14 @@ func (c *Client) forwardMessages() {
 				errorf("unable to broadcast DI: %w", err)
 				return
 			}
+		case batch := <-c.resume:
+			for _, di := range batch {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.conn.WriteJSON(di); err != nil {
+					errorf("unable to replay DI: %w", err)
+					return
+				}
+			}
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2020 Jecoz
+//
+// SPDX-License-Identifier: MIT
+
+package ws
+
+import (
+	"strconv"
+	"sync"
+)
+
+// defaultBacklogSize bounds how many broadcast DIs the hub keeps around
+// by default for clients that need to catch up after connecting, e.g. a
+// reconnecting websocket client or an SSE stream resuming from a
+// Last-Event-ID.
+const defaultBacklogSize = 64
+
+// Hub fans out every DI read from the CSV input to the registered
+// websocket and SSE clients, and keeps the `register`/`unregister`
+// bookkeeping local to a single goroutine (run) so the client maps
+// never need their own lock.
+type Hub struct {
+	clients    map[*Client]bool
+	sseClients map[*sseClient]bool
+	oscPeers   map[*oscPeer]bool
+
+	broadcast chan *DI
+
+	register   chan *Client
+	unregister chan *Client
+
+	registerSSE   chan *sseRegistration
+	unregisterSSE chan *sseClient
+
+	registerOSC   chan *oscPeer
+	unregisterOSC chan *oscPeer
+
+	backlog struct {
+		sync.Mutex
+		size  int
+		items []*DI
+		seq   uint64
+	}
+}
+
+func newHub(backlogSize int) *Hub {
+	if backlogSize <= 0 {
+		backlogSize = defaultBacklogSize
+	}
+	h := &Hub{
+		clients:       make(map[*Client]bool),
+		sseClients:    make(map[*sseClient]bool),
+		oscPeers:      make(map[*oscPeer]bool),
+		broadcast:     make(chan *DI),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		registerSSE:   make(chan *sseRegistration),
+		unregisterSSE: make(chan *sseClient),
+		registerOSC:   make(chan *oscPeer),
+		unregisterOSC: make(chan *oscPeer),
+	}
+	h.backlog.size = backlogSize
+	return h
+}
+
+// backlogCap returns the hub's configured backlog size (after the
+// defaultBacklogSize fallback has been applied), so callers sizing a
+// client's send buffer can fit the whole backlog instead of assuming
+// the default. It's set once at construction, so reading it needs no
+// lock.
+func (h *Hub) backlogCap() int {
+	return h.backlog.size
+}
+
+// snapshotSince returns the backlog entries following the one with the
+// given id, oldest first. An empty id (or one no longer present in the
+// backlog) returns the whole backlog.
+func (h *Hub) snapshotSince(id string) []*DI {
+	h.backlog.Lock()
+	defer h.backlog.Unlock()
+
+	items := h.backlog.items
+	if id != "" {
+		for i, di := range items {
+			if di.ID == id {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+
+	out := make([]*DI, len(items))
+	copy(out, items)
+	return out
+}
+
+// record assigns di the next sequence id and appends it to the
+// backlog. It is the single point every broadcast DI passes through,
+// so the on-screen event and its synthesized off/late copies (distinct
+// *DI values, see Scheduler.drain/emit) each get their own stable,
+// monotonically-increasing id instead of inheriting one assigned
+// earlier at ingest.
+func (h *Hub) record(di *DI) {
+	h.backlog.Lock()
+	defer h.backlog.Unlock()
+
+	h.backlog.seq++
+	di.ID = strconv.FormatUint(h.backlog.seq, 36)
+
+	h.backlog.items = append(h.backlog.items, di)
+	if len(h.backlog.items) > h.backlog.size {
+		h.backlog.items = h.backlog.items[len(h.backlog.items)-h.backlog.size:]
+	}
+}
+
+// seed pushes di onto c, dropping it rather than blocking the hub's run
+// loop if the client is behind.
+func seed(c chan *DI, di *DI) {
+	select {
+	case c <- di:
+	default:
+	}
+}
+
+func (h *Hub) run() {
+	for {
+		select {
+		case c := <-h.register:
+			h.clients[c] = true
+			for _, di := range h.snapshotSince("") {
+				seed(c.send, di)
+			}
+		case c := <-h.unregister:
+			if _, ok := h.clients[c]; ok {
+				delete(h.clients, c)
+				close(c.send)
+			}
+		case reg := <-h.registerSSE:
+			h.sseClients[reg.client] = true
+			for _, di := range h.snapshotSince(reg.sinceID) {
+				seed(reg.client.send, di)
+			}
+		case c := <-h.unregisterSSE:
+			if _, ok := h.sseClients[c]; ok {
+				delete(h.sseClients, c)
+				close(c.send)
+			}
+		case p := <-h.registerOSC:
+			h.oscPeers[p] = true
+		case p := <-h.unregisterOSC:
+			if _, ok := h.oscPeers[p]; ok {
+				delete(h.oscPeers, p)
+				close(p.send)
+			}
+		case di := <-h.broadcast:
+			h.record(di)
+			for c := range h.clients {
+				select {
+				case c.send <- di:
+				default:
+					close(c.send)
+					delete(h.clients, c)
+				}
+			}
+			for c := range h.sseClients {
+				select {
+				case c.send <- di:
+				default:
+					close(c.send)
+					delete(h.sseClients, c)
+				}
+			}
+			for p := range h.oscPeers {
+				select {
+				case p.send <- di:
+				default:
+					close(p.send)
+					delete(h.oscPeers, p)
+				}
+			}
+		}
+	}
+}
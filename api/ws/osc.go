@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2020 Jecoz
+//
+// SPDX-License-Identifier: MIT
+
+package ws
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/hypebeast/go-osc/osc"
+)
+
+// oscPeer mirrors Client/sseClient: it registers with the hub's
+// broadcast fan-out, but forwards each DI to a remote OSC peer as a
+// bundle instead of writing it to a local connection. It is registered
+// via a `/di/subscribe <host> <port>` control message.
+type oscPeer struct {
+	Addr   string
+	client *osc.Client
+	send   chan *DI
+}
+
+func diMessage(di *DI) *osc.Message {
+	msg := osc.NewMessage("/di/broadcast")
+	msg.Append(di.ID)
+	msg.Append(di.Word)
+	msg.Append(di.FileName)
+	msg.Append(di.Off)
+	msg.Append(di.Late)
+	return msg
+}
+
+func (p *oscPeer) run() {
+	for di := range p.send {
+		bundle := osc.NewBundle(time.Now())
+		bundle.Append(diMessage(di))
+		if err := p.client.Send(bundle); err != nil {
+			errorf("unable to forward di to osc peer %v: %v", p.Addr, err)
+		}
+	}
+}
+
+func oscArgString(msg *osc.Message, i int) (string, error) {
+	if i >= len(msg.Arguments) {
+		return "", fmt.Errorf("missing argument at position %d", i)
+	}
+	v, ok := msg.Arguments[i].(string)
+	if !ok {
+		return "", fmt.Errorf("argument at position %d is not a string", i)
+	}
+	return v, nil
+}
+
+func oscArgInt(msg *osc.Message, i int) (int32, error) {
+	if i >= len(msg.Arguments) {
+		return 0, fmt.Errorf("missing argument at position %d", i)
+	}
+	v, ok := msg.Arguments[i].(int32)
+	if !ok {
+		return 0, fmt.Errorf("argument at position %d is not an int32", i)
+	}
+	return v, nil
+}
+
+// dispatcher builds the standard dispatcher routing inbound OSC control
+// messages into hub/scheduler actions, so a Max/MSP patch can drive the
+// stream instead of only receiving from it.
+func (s *Server) dispatcher() *osc.StandardDispatcher {
+	d := osc.NewStandardDispatcher()
+
+	d.AddMsgHandler("/di/next", func(msg *osc.Message) {
+		s.sched.ForceNext()
+	})
+	d.AddMsgHandler("/di/pause", func(msg *osc.Message) {
+		s.sched.Pause()
+	})
+	d.AddMsgHandler("/di/resume", func(msg *osc.Message) {
+		s.sched.Resume()
+	})
+	d.AddMsgHandler("/di/seek", func(msg *osc.Message) {
+		ms, err := oscArgInt(msg, 0)
+		if err != nil {
+			errorf("/di/seek: %v", err)
+			return
+		}
+		s.sched.Seek(time.Duration(ms) * time.Millisecond)
+	})
+	d.AddMsgHandler("/di/subscribe", func(msg *osc.Message) {
+		host, err := oscArgString(msg, 0)
+		if err != nil {
+			errorf("/di/subscribe: %v", err)
+			return
+		}
+		port, err := oscArgInt(msg, 1)
+		if err != nil {
+			errorf("/di/subscribe: %v", err)
+			return
+		}
+
+		peer := &oscPeer{
+			Addr:   net.JoinHostPort(host, strconv.Itoa(int(port))),
+			client: osc.NewClient(host, int(port)),
+			send:   make(chan *DI, 50),
+		}
+		go peer.run()
+		s.hub.registerOSC <- peer
+	})
+
+	return d
+}
+
+// ListenOSC runs an OSC server on addr that lets a Max/MSP patch drive
+// the stream: `/di/next` forces emission of whatever is next queued,
+// `/di/pause`/`/di/resume` gate the scheduler, `/di/seek <ms>` reseats
+// its anchor, and `/di/subscribe <host> <port>` registers a peer that
+// receives every broadcast DI as an OSC bundle alongside the
+// websocket/SSE clients. It blocks until the underlying UDP listener
+// fails or is closed.
+func (s *Server) ListenOSC(addr string) error {
+	srv := &osc.Server{
+		Addr:       addr,
+		Dispatcher: s.dispatcher(),
+	}
+	logf("osc server listening on %v", addr)
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2020 Jecoz
+//
+// SPDX-License-Identifier: MIT
+
+package ws
+
+import (
+	"sync"
+	"time"
+)
+
+// Scheduler turns a DI's StartAt/EndAt offsets into actual broadcast
+// timing against a shared media clock, instead of the hub emitting each
+// DI as soon as the CSV row is read. Offset 0 is the anchor time; it can
+// be reseated to the wall clock at server start, to an offset a client
+// supplies over the websocket control channel, or by an OSC `/di/seek`
+// message driving a Max/MSP transport.
+type Scheduler struct {
+	hub *Hub
+
+	anchor struct {
+		sync.Mutex
+		t time.Time
+	}
+
+	clientAnchorOnce sync.Once
+
+	queues struct {
+		sync.Mutex
+		m map[string]chan *DI
+	}
+
+	pause struct {
+		sync.Mutex
+		paused bool
+		resume chan struct{}
+	}
+
+	// force queues a single nudge for whichever per-word drain goroutine
+	// next reaches its timer select, making it broadcast immediately
+	// instead of waiting out its StartAt/EndAt offset. Buffered by one so
+	// a `/di/next` that arrives while every goroutine is between waits
+	// (idle, or blocked in waitIfPaused) still takes effect on the next
+	// one to wait, rather than being silently dropped.
+	force chan struct{}
+}
+
+func newScheduler(hub *Hub) *Scheduler {
+	s := &Scheduler{hub: hub, force: make(chan struct{}, 1)}
+	s.anchor.t = time.Now()
+	s.queues.m = make(map[string]chan *DI)
+	return s
+}
+
+// SetAnchor reseats the scheduler's reference time: offset 0 becomes
+// `t`.
+func (s *Scheduler) SetAnchor(t time.Time) {
+	s.anchor.Lock()
+	s.anchor.t = t
+	s.anchor.Unlock()
+}
+
+// Seek scrubs the media clock so that offset `at` is "now".
+func (s *Scheduler) Seek(at time.Duration) {
+	s.SetAnchor(time.Now().Add(-at))
+}
+
+// SetClientAnchor is Seek, but only takes effect the first time it is
+// called: per the spec, only the first client to supply an anchor
+// offset gets to set it.
+func (s *Scheduler) SetClientAnchor(at time.Duration) {
+	s.clientAnchorOnce.Do(func() {
+		s.Seek(at)
+	})
+}
+
+// ForceNext makes whichever queued DI is currently (or next) waiting
+// out its StartAt/EndAt offset broadcast right away, e.g. in response
+// to an OSC `/di/next` message. With several words queued concurrently,
+// which one fires is whichever drain goroutine happens to be selected;
+// this is a manual nudge, not a precise "play the earliest one"
+// operation. The nudge is queued (buffered by one), so it still takes
+// effect if nothing is mid-wait at the instant it arrives; a second
+// ForceNext before the first is consumed is dropped, same as before.
+func (s *Scheduler) ForceNext() {
+	select {
+	case s.force <- struct{}{}:
+	default:
+	}
+}
+
+// Pause gates broadcasting: queued DIs still count down their offsets,
+// but emit blocks until Resume is called.
+func (s *Scheduler) Pause() {
+	s.pause.Lock()
+	defer s.pause.Unlock()
+	if s.pause.paused {
+		return
+	}
+	s.pause.paused = true
+	s.pause.resume = make(chan struct{})
+}
+
+// Resume undoes Pause.
+func (s *Scheduler) Resume() {
+	s.pause.Lock()
+	defer s.pause.Unlock()
+	if !s.pause.paused {
+		return
+	}
+	s.pause.paused = false
+	close(s.pause.resume)
+}
+
+func (s *Scheduler) waitIfPaused() {
+	s.pause.Lock()
+	paused, resume := s.pause.paused, s.pause.resume
+	s.pause.Unlock()
+	if paused {
+		<-resume
+	}
+}
+
+func (s *Scheduler) elapsed() time.Duration {
+	s.anchor.Lock()
+	defer s.anchor.Unlock()
+	return time.Since(s.anchor.t)
+}
+
+func (s *Scheduler) queueFor(word string) chan *DI {
+	s.queues.Lock()
+	defer s.queues.Unlock()
+
+	c, ok := s.queues.m[word]
+	if !ok {
+		c = make(chan *DI, 64)
+		s.queues.m[word] = c
+		go s.drain(c)
+	}
+	return c
+}
+
+// Schedule queues di to be broadcast at its StartAt offset, plus a
+// synthesized off-screen DI at its EndAt offset. Items sharing a Word
+// are drained in order by a single per-word goroutine, so overlapping
+// records queue instead of clobbering or dropping one another.
+func (s *Scheduler) Schedule(di *DI) {
+	s.queueFor(di.Word) <- di
+}
+
+func (s *Scheduler) drain(c chan *DI) {
+	for di := range c {
+		s.emit(di, di.StartAt)
+
+		off := *di
+		off.Off = true
+		s.emit(&off, di.EndAt)
+	}
+}
+
+// emit waits until `at` has elapsed on the media clock before
+// broadcasting di, unless an OSC `/di/next` nudge (s.force) arrives
+// first. Offsets already in the past (StartAt earlier than the current
+// anchor-relative elapsed time) are emitted immediately, flagged as
+// late so clients can decide whether to still show them.
+func (s *Scheduler) emit(di *DI, at time.Duration) {
+	wait := at - s.elapsed()
+	if wait <= 0 {
+		late := *di
+		late.Late = true
+		s.broadcast(&late)
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-s.force:
+	}
+	s.broadcast(di)
+}
+
+func (s *Scheduler) broadcast(di *DI) {
+	s.waitIfPaused()
+	s.hub.broadcast <- di
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2020 Jecoz
+//
+// SPDX-License-Identifier: MIT
+
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+// drainBroadcast forwards everything the scheduler sends to hub.broadcast
+// into a channel the test can read, without running the full Hub.run loop.
+func drainBroadcast(hub *Hub) <-chan *DI {
+	out := make(chan *DI, 16)
+	go func() {
+		for di := range hub.broadcast {
+			out <- di
+		}
+	}()
+	return out
+}
+
+func TestSchedulerEmitsOnThenOff(t *testing.T) {
+	hub := newHub(0)
+	sched := newScheduler(hub)
+	received := drainBroadcast(hub)
+
+	sched.Schedule(&DI{Word: "cat", StartAt: 20 * time.Millisecond, EndAt: 40 * time.Millisecond})
+
+	on := waitDI(t, received)
+	if on.Off || on.Late {
+		t.Fatalf("first emitted DI = %+v, want the plain on-screen event", on)
+	}
+
+	off := waitDI(t, received)
+	if !off.Off {
+		t.Fatalf("second emitted DI = %+v, want Off = true", off)
+	}
+}
+
+func TestSchedulerFlagsLateArrivals(t *testing.T) {
+	hub := newHub(0)
+	sched := newScheduler(hub)
+	received := drainBroadcast(hub)
+
+	// Seat the anchor a second in the past, so StartAt has already
+	// elapsed on the media clock by the time the DI is scheduled.
+	sched.SetAnchor(time.Now().Add(-time.Second))
+	sched.Schedule(&DI{Word: "dog", StartAt: 10 * time.Millisecond, EndAt: 20 * time.Millisecond})
+
+	on := waitDI(t, received)
+	if !on.Late {
+		t.Fatalf("on-screen DI = %+v, want Late = true for an already-elapsed StartAt", on)
+	}
+}
+
+func TestSchedulerForceNextQueuesAheadOfArrival(t *testing.T) {
+	hub := newHub(0)
+	sched := newScheduler(hub)
+	received := drainBroadcast(hub)
+
+	sched.Schedule(&DI{Word: "bird", StartAt: 5 * time.Second, EndAt: 6 * time.Second})
+	// Nudge immediately: the drain goroutine may not have reached its
+	// timer select yet, which is exactly the case the buffered force
+	// channel is meant to cover.
+	sched.ForceNext()
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatalf("ForceNext did not force emission ahead of the 5s StartAt wait")
+	}
+}
+
+func waitDI(t *testing.T, c <-chan *DI) *DI {
+	t.Helper()
+	select {
+	case di := <-c:
+		return di
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for a broadcast DI")
+		return nil
+	}
+}
@@ -34,11 +34,6 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-type FileSystem interface {
-	Exists(string) bool
-	Create(string) (*os.File, error)
-}
-
 var arg0 = filepath.Base(os.Args[0])
 
 func logf(format string, args ...interface{}) {
@@ -81,11 +76,30 @@ func (m *mapping) max() int {
 }
 
 type DI struct {
+	// ID is a stable, monotonically-increasing identifier (base36-
+	// encoded sequence number) assigned by the hub when this DI is
+	// broadcast, so a client can resume a subscription from a given
+	// point in the backlog. Every broadcast frame gets its own id, the
+	// synthesized off/late events included.
+	ID       string        `json:"id"`
 	StartAt  time.Duration `json:"start_at"`
 	EndAt    time.Duration `json:"end_at"`
 	Link     string        `json:"link"`
 	Word     string        `json:"word"`
 	FileName string        `json:"file_name"`
+
+	// Off marks the synthesized off-screen event the scheduler emits at
+	// EndAt, as opposed to the on-screen event emitted at StartAt.
+	Off bool `json:"off,omitempty"`
+	// Late marks a DI whose offset had already elapsed on the media
+	// clock by the time it reached the front of its word's queue.
+	Late bool `json:"late,omitempty"`
+
+	// BytesTotal is the Content-Length advertised by the image
+	// download, or -1 if the response advertised none. It is set once
+	// headers arrive, well before the file is fully written to disk; a
+	// DI whose file was already on disk carries 0.
+	BytesTotal int64 `json:"bytes_total,omitempty"`
 }
 
 type Server struct {
@@ -94,7 +108,30 @@ type Server struct {
 	Port    int
 	Done    chan error
 	Mapping *mapping
-	hub     *Hub
+
+	// BacklogSize bounds how many broadcast DIs the hub keeps around for
+	// subscribers to replay on connect or resume. 0 picks defaultBacklogSize.
+	BacklogSize int
+
+	// OscAddr is the address (e.g. "localhost:5499") the inbound OSC
+	// dispatcher listens on. Empty disables it.
+	OscAddr string
+
+	// Workers bounds how many image downloads run concurrently. <= 0
+	// picks 1.
+	Workers int
+
+	// ByteBudget caps the bytes reserved across in-flight downloads at
+	// once: a download whose next chunk would push the total over
+	// budget is aborted, keeping whatever it had already written. A
+	// download's bytes return to the budget once it ends, so this bounds
+	// concurrent in-flight bytes, not the cumulative total written over
+	// a long-running CSV. <= 0 means unlimited.
+	ByteBudget int64
+
+	hub        *Hub
+	sched      *Scheduler
+	downloader *api.Downloader
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -181,33 +218,11 @@ func decodeDI(m *mapping, rec []string) (*DI, error) {
 	}, nil
 }
 
-func downloadImage(fs FileSystem, di *DI) error {
-	// If the file is already there, do not download again.
-	if fs.Exists(di.FileName) {
-		return nil
-	}
-
-	// Otherwise download it.
-	f, err := fs.Create(di.FileName)
-	if err != nil {
-		return fmt.Errorf("unable to prepare file for storing image: %w", err)
-	}
-	defer f.Close()
-
-	logf("downloading image for: %v", di.FileName)
-
-	resp, err := http.Get(di.Link)
-	if err != nil {
-		return fmt.Errorf("unable to download image: %w", err)
-	}
-	defer resp.Body.Close()
-	if _, err = io.Copy(f, resp.Body); err != nil {
-		return fmt.Errorf("unable to store image: %w", err)
-	}
-
-	return nil
-}
-
+// read keeps on reading from in, scheduling each decoded DI for
+// broadcast. Images not yet on disk are downloaded in the background
+// via s.downloader: the DI is scheduled as soon as the download's
+// headers arrive, rather than once the file is fully written, so the
+// CSV read loop never blocks on a slow or large image.
 func (s *Server) read(ctx context.Context, in io.Reader) {
 	r := csv.NewReader(in)
 	for {
@@ -230,13 +245,19 @@ func (s *Server) read(ctx context.Context, in io.Reader) {
 			errorf(err.Error())
 			continue
 		}
-		if err = downloadImage(s.Fh, di); err != nil {
-			errorf(err.Error())
+
+		if s.Fh.Exists(di.FileName) {
+			logf("---> %v", di.FileName)
+			s.sched.Schedule(di)
 			continue
 		}
 
-		logf("---> %v", di.FileName)
-		s.hub.broadcast <- di
+		s.downloader.Fetch(di.Link, di.FileName, func(bytesTotal int64) {
+			seeded := *di
+			seeded.BytesTotal = bytesTotal
+			logf("---> %v", seeded.FileName)
+			s.sched.Schedule(&seeded)
+		})
 	}
 }
 
@@ -244,11 +265,15 @@ func (s *Server) Run(ctx context.Context, in io.Reader) {
 	logf("opening stream handler loop")
 	defer logf("closing stream handler loop")
 
-	s.hub = newHub()
+	s.hub = newHub(s.BacklogSize)
+	s.sched = newScheduler(s.hub)
+	s.downloader = api.NewDownloader(s.Fh, s.Workers, s.ByteBudget)
 	s.Done = make(chan error, 1)
 	mux := http.NewServeMux()
 	mux.Handle("/di/images/", http.StripPrefix("/di/images/", s.Fh))
+	mux.Handle("/di/upload/", http.StripPrefix("/di/upload/", http.HandlerFunc(s.Fh.ServeUpload)))
 	mux.Handle("/di/stream", s)
+	mux.HandleFunc("/di/events", s.ServeSSE)
 
 	host := net.JoinHostPort("", strconv.Itoa(s.Port))
 	srv := &http.Server{
@@ -265,6 +290,14 @@ func (s *Server) Run(ctx context.Context, in io.Reader) {
 	go s.hub.run()
 	go s.read(ctx, in)
 
+	if s.OscAddr != "" {
+		go func() {
+			if err := s.ListenOSC(s.OscAddr); err != nil {
+				logf("osc server listener error: %v", err)
+			}
+		}()
+	}
+
 	go func() {
 		logf("server listening on %v", host)
 		if err := srv.ListenAndServe(); err != nil {
@@ -297,11 +330,13 @@ func (s *Server) ServeWs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	client := &Client{
-		Addr: r.RemoteAddr,
-		hub:  s.hub,
-		conn: conn,
-		send: make(chan *DI, 50),
-		osc:  s.Osc,
+		Addr:   r.RemoteAddr,
+		hub:    s.hub,
+		sched:  s.sched,
+		conn:   conn,
+		send:   make(chan *DI, s.hub.backlogCap()+50),
+		resume: make(chan []*DI, 1),
+		osc:    s.Osc,
 	}
 	client.hub.register <- client
 
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2020 Jecoz
+//
+// SPDX-License-Identifier: MIT
+
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sseClient is the SSE equivalent of Client: it registers with the same
+// Hub.broadcast fan-out but is written to as a text/event-stream instead
+// of a websocket connection, so browsers and curl-based tooling can
+// subscribe without the websocket upgrade dance.
+type sseClient struct {
+	Addr string
+	hub  *Hub
+
+	// Buffered channel of outbound messages.
+	send chan *DI
+}
+
+// sseRegistration carries the Last-Event-ID (if any) alongside the
+// client into the hub's run loop, so seeding the backlog happens
+// atomically with registration: broadcasts racing the connection can
+// land in neither, or in both, but never slip through the gap between
+// a snapshot taken outside run and the client being added to
+// sseClients.
+type sseRegistration struct {
+	client  *sseClient
+	sinceID string
+}
+
+func writeDI(w http.ResponseWriter, di *DI) error {
+	b, err := json.Marshal(di)
+	if err != nil {
+		return fmt.Errorf("unable to encode di: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: di\ndata: %s\n\n", di.ID, b)
+	return err
+}
+
+// ServeSSE implements the `/di/events` endpoint: a first-class
+// alternative to ServeWs for clients that only want to read the stream.
+// New subscribers receive the whole backlog on connect; a client that
+// reconnects may instead set the Last-Event-ID header (as set
+// automatically by the browser EventSource API) to receive only the
+// entries broadcast since that id.
+func (s *Server) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	client := &sseClient{
+		Addr: r.RemoteAddr,
+		hub:  s.hub,
+		send: make(chan *DI, s.hub.backlogCap()+50),
+	}
+
+	client.hub.registerSSE <- &sseRegistration{client: client, sinceID: r.Header.Get("Last-Event-ID")}
+	defer func() {
+		client.hub.unregisterSSE <- client
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case di, ok := <-client.send:
+			if !ok {
+				return
+			}
+			if err := writeDI(w, di); err != nil {
+				errorf("sse: %v", err)
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				errorf("sse: %v", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 
 	"github.com/discursive-image/dis/api"
 	"github.com/discursive-image/dis/api/ws"
@@ -47,10 +49,15 @@ func main() {
 	p := flag.Int("p", 7745, "Server listening port.")
 	oscp := flag.Int("oscp", 5498, "OSC server listening port.")
 	osch := flag.String("osch", "localhost", "OSC server host.")
+	oscl := flag.Int("oscl", 5499, "Inbound OSC dispatcher listening port, letting Max drive the stream. 0 disables it.")
+	oscip := flag.String("oscip", "localhost", "Inbound OSC dispatcher listening ip.")
 	cs := flag.Int("cs", 1, "Index of the column holding start information.")
 	ce := flag.Int("ce", 2, "Index of the column holding end information.")
 	cw := flag.Int("cw", 3, "Index of the column holding spoken word.")
 	cl := flag.Int("cl", 6, "Index of the column holding image link.")
+	backlog := flag.Int("backlog", 64, "Number of broadcast DIs kept around for subscribers to replay on connect or resume.")
+	dlworkers := flag.Int("dlworkers", 4, "Number of concurrent image downloads.")
+	dlbudget := flag.Int64("dlbudget", 0, "Maximum total bytes reserved for in-flight downloads at once. 0 means unlimited.")
 	flag.Parse()
 
 	// Prepare input.
@@ -61,11 +68,20 @@ func main() {
 	}
 	defer in.Close()
 
+	var oscAddr string
+	if *oscl > 0 {
+		oscAddr = net.JoinHostPort(*oscip, strconv.Itoa(*oscl))
+	}
+
 	srv := &ws.Server{
-		Fh:      api.NewFileHandler(*sd),
-		Port:    *p,
-		Mapping: ws.NewMapping(*cs, *ce, *cw, *cl),
-		Osc:     osc.NewClient(*osch, *oscp),
+		Fh:          api.NewFileHandler(*sd, nil),
+		Port:        *p,
+		Mapping:     ws.NewMapping(*cs, *ce, *cw, *cl),
+		Osc:         osc.NewClient(*osch, *oscp),
+		BacklogSize: *backlog,
+		OscAddr:     oscAddr,
+		Workers:     *dlworkers,
+		ByteBudget:  *dlbudget,
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
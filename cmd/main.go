@@ -55,6 +55,9 @@ func main() {
 	ce := flag.Int("ce", 2, "Index of the column holding end information.")
 	cw := flag.Int("cw", 3, "Index of the column holding spoken word.")
 	cl := flag.Int("cl", 6, "Index of the column holding image link.")
+	backlog := flag.Int("backlog", 64, "Number of broadcast DIs kept around for subscribers to replay on connect or resume.")
+	dlworkers := flag.Int("dlworkers", 4, "Number of concurrent image downloads.")
+	dlbudget := flag.Int64("dlbudget", 0, "Maximum total bytes reserved for in-flight downloads at once. 0 means unlimited.")
 	flag.Parse()
 
 	// Prepare input.
@@ -66,12 +69,15 @@ func main() {
 	defer in.Close()
 
 	// Register the file handler.
-	fh := api.NewFileHandler(*sd)
+	fh := api.NewFileHandler(*sd, nil)
 	http.Handle("/di/images/", http.StripPrefix("/di/images/", fh))
+	http.Handle("/di/upload/", http.StripPrefix("/di/upload/", http.HandlerFunc(fh.ServeUpload)))
 
 	// Register stream handler.
-	sh := api.NewStreamHandler(in, fh, api.NewMapSet(*cs, *ce, *cw, *cl))
+	dl := api.NewDownloader(fh, *dlworkers, *dlbudget)
+	sh := api.NewStreamHandler(in, fh, dl, api.NewMapSet(*cs, *ce, *cw, *cl), *backlog)
 	http.Handle("/di/stream", sh)
+	http.HandleFunc("/di/events", sh.ServeSSE)
 
 	// Configure server.
 	host := ":" + strconv.Itoa(*p)
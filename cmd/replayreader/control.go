@@ -0,0 +1,238 @@
+// SPDX-FileCopyrightText: 2020 Jecoz
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/discursive-image/dis/api"
+)
+
+// control is the replay loop's virtual clock plus its control-plane
+// bookkeeping: pausing, seeking and changing the playback rate all act
+// on it, and waitUntil is how the emission loop observes those changes
+// without dropping whatever record it is currently waiting to emit.
+type control struct {
+	origStart time.Time // fixed at startup, used to measure drift
+
+	mu    sync.Mutex
+	start time.Time // offset 0 maps to this wall-clock time
+	rate  float64   // playback rate multiplier
+
+	pause struct {
+		sync.Mutex
+		paused bool
+		resume chan struct{}
+	}
+
+	// nudge wakes up a blocked waitUntil so a seek, rate change or
+	// pause takes effect immediately instead of once the current,
+	// now-stale wait elapses.
+	nudge chan struct{}
+
+	status struct {
+		sync.Mutex
+		emitted int64
+		nextAt  time.Duration
+	}
+}
+
+func newControl() *control {
+	now := time.Now()
+	c := &control{
+		origStart: now,
+		start:     now,
+		rate:      1,
+		nudge:     make(chan struct{}),
+	}
+	return c
+}
+
+func (c *control) wake() {
+	select {
+	case c.nudge <- struct{}{}:
+	default:
+	}
+}
+
+// Seek advances the virtual clock's reference so that offset `to` is
+// "now".
+func (c *control) Seek(to time.Duration) {
+	c.mu.Lock()
+	c.start = time.Now().Add(-time.Duration(float64(to) / c.rate))
+	c.mu.Unlock()
+	c.wake()
+}
+
+// SetRate changes the multiplier waitUntil applies to a record's
+// remaining wait, so 2.0 plays twice as fast and 0.5 plays at half
+// speed.
+func (c *control) SetRate(x float64) {
+	c.mu.Lock()
+	// Rebase start so the virtual playhead stays where it is and only
+	// its speed changes from here on.
+	now := time.Now()
+	at := time.Duration(float64(now.Sub(c.start)) * c.rate)
+	c.start = now.Add(-time.Duration(float64(at) / x))
+	c.rate = x
+	c.mu.Unlock()
+	c.wake()
+}
+
+func (c *control) elapsed() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Duration(float64(time.Since(c.start)) * c.rate)
+}
+
+func (c *control) rateValue() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rate
+}
+
+// Pause gates waitUntil: a wait in progress (or about to start) blocks
+// until Resume is called.
+func (c *control) Pause() {
+	c.pause.Lock()
+	if !c.pause.paused {
+		c.pause.paused = true
+		c.pause.resume = make(chan struct{})
+	}
+	c.pause.Unlock()
+	c.wake()
+}
+
+// Resume undoes Pause.
+func (c *control) Resume() {
+	c.pause.Lock()
+	if c.pause.paused {
+		c.pause.paused = false
+		close(c.pause.resume)
+	}
+	c.pause.Unlock()
+	c.wake()
+}
+
+func (c *control) waitIfPaused() {
+	c.pause.Lock()
+	paused, resume := c.pause.paused, c.pause.resume
+	c.pause.Unlock()
+	if paused {
+		<-resume
+	}
+}
+
+// waitUntil blocks until `at` has elapsed on the virtual clock,
+// honoring the current rate and any pause. A seek, rate change or
+// pause/resume arriving mid-wait interrupts it so it can recompute the
+// remaining duration instead of sleeping out a stale one.
+func (c *control) waitUntil(at time.Duration) {
+	c.setNext(at)
+	for {
+		c.waitIfPaused()
+
+		remaining := time.Duration(float64(at-c.elapsed()) / c.rateValue())
+		if remaining <= 0 {
+			return
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+			return
+		case <-c.nudge:
+			timer.Stop()
+		}
+	}
+}
+
+func (c *control) setNext(at time.Duration) {
+	c.status.Lock()
+	c.status.nextAt = at
+	c.status.Unlock()
+}
+
+func (c *control) recordEmitted() {
+	c.status.Lock()
+	c.status.emitted++
+	c.status.Unlock()
+}
+
+// statusResponse is what GET /status reports.
+type statusResponse struct {
+	Playhead string  `json:"playhead"`
+	NextETA  string  `json:"next_eta"`
+	Emitted  int64   `json:"emitted"`
+	Drift    string  `json:"drift"`
+	Paused   bool    `json:"paused"`
+	Rate     float64 `json:"rate"`
+}
+
+func (c *control) Status() statusResponse {
+	c.status.Lock()
+	emitted, nextAt := c.status.emitted, c.status.nextAt
+	c.status.Unlock()
+
+	c.pause.Lock()
+	paused := c.pause.paused
+	c.pause.Unlock()
+
+	elapsed := c.elapsed()
+	return statusResponse{
+		Playhead: elapsed.String(),
+		NextETA:  (nextAt - elapsed).String(),
+		Emitted:  emitted,
+		Drift:    (elapsed - time.Since(c.origStart)).String(),
+		Paused:   paused,
+		Rate:     c.rateValue(),
+	}
+}
+
+// serveControl exposes the replay loop's control plane: GET /status,
+// POST /pause, POST /resume, POST /seek?to=00:12:34.000 and
+// POST /rate?x=2.0, plus a static console page (if webDir is set) that
+// polls /status and offers buttons for the same operations.
+func serveControl(addr string, ctl *control, webDir string) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ctl.Status())
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		ctl.Pause()
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		ctl.Resume()
+	})
+	mux.HandleFunc("/seek", func(w http.ResponseWriter, r *http.Request) {
+		to, err := parseSgencDuration(r.URL.Query().Get("to"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctl.Seek(to)
+	})
+	mux.HandleFunc("/rate", func(w http.ResponseWriter, r *http.Request) {
+		x, err := strconv.ParseFloat(r.URL.Query().Get("x"), 64)
+		if err != nil || x <= 0 {
+			http.Error(w, "invalid rate", http.StatusBadRequest)
+			return
+		}
+		ctl.SetRate(x)
+	})
+	if webDir != "" {
+		mux.Handle("/", api.NewFileHandler(webDir, nil))
+	}
+
+	logf("control plane listening on %v", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		errorf("control plane listener error: %v\n", err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"bufio"
 	"encoding/csv"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
@@ -19,6 +20,10 @@ import (
 
 var arg0 = filepath.Base(os.Args[0])
 
+func logf(format string, args ...interface{}) {
+	fmt.Printf(arg0+" * "+format+"\n", args...)
+}
+
 func errorf(format string, args ...interface{}) {
 	fmt.Printf(arg0+" error * "+format, args...)
 }
@@ -28,10 +33,22 @@ func exitf(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
+// DurationParser turns a single timestamp field into a time.Duration,
+// so the replayer isn't tied to one timestamp format.
+type DurationParser func(string) (time.Duration, error)
+
+// durationParsers is the registry -format picks from.
+var durationParsers = map[string]DurationParser{
+	"sgenc": parseSgencDuration,
+	"srt":   parseSRTDuration,
+	"vtt":   parseVTTDuration,
+	"go":    time.ParseDuration,
+}
+
 // copy/pasted from https://git.keepinmind.info/subgensdk/sgenc,
 // Licensed under MIT, still not open source.
 // TODO: import the library as soon as it is available.
-func parseDuration(raw string) (time.Duration, error) {
+func parseSgencDuration(raw string) (time.Duration, error) {
 	parts := strings.Split(raw, ".")
 	if len(parts) != 2 {
 		return 0, fmt.Errorf("unable to split duration units from decimals")
@@ -73,31 +90,117 @@ func parseDuration(raw string) (time.Duration, error) {
 	return d, nil
 }
 
+// parseSRTDuration parses SubRip's "HH:MM:SS,mmm" timestamps, which are
+// otherwise identical to the sgenc form bar the decimal separator.
+func parseSRTDuration(raw string) (time.Duration, error) {
+	parts := strings.SplitN(raw, ",", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("unable to split duration units from milliseconds")
+	}
+	return parseSgencDuration(parts[0] + "." + parts[1])
+}
+
+// parseVTTDuration parses WebVTT's "HH:MM:SS.mmm" timestamps, plus the
+// shorter "MM:SS.mmm" form the spec allows when hours are zero.
+func parseVTTDuration(raw string) (time.Duration, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("unable to split duration units from decimals")
+	}
+	units := strings.Split(parts[0], ":")
+	switch len(units) {
+	case 2:
+		units = append([]string{"00"}, units...)
+	case 3:
+	default:
+		return 0, fmt.Errorf("duration units should be in the form of [hh:]mm:ss, found %s", parts[0])
+	}
+	return parseSgencDuration(strings.Join(units, ":") + "." + parts[1])
+}
+
+// detectFormat guesses which registered parser matches raw's separator
+// characters, for -format=auto.
+func detectFormat(raw string) string {
+	switch {
+	case strings.Contains(raw, ","):
+		return "srt"
+	case strings.ContainsAny(raw, "hms") && !strings.Contains(raw, ":"):
+		return "go"
+	case strings.Count(raw, ":") == 1:
+		return "vtt"
+	default:
+		return "sgenc"
+	}
+}
+
+// record is a decoded input row paired with the StartAt-like offset it
+// should be emitted at, handed from the reading goroutine to the
+// emission loop over a channel.
+type record struct {
+	rec []string
+	at  time.Duration
+}
+
 func main() {
-	r := csv.NewReader(bufio.NewReader(os.Stdin))
-	r.ReuseRecord = true
+	format := flag.String("format", "sgenc", "Timestamp format of the input's duration column: auto, sgenc, srt, vtt or go.")
+	ctlAddr := flag.String("ctl", "", "Control-plane HTTP listen address (e.g. :7746) exposing /status, /pause, /resume, /seek and /rate. Empty disables it.")
+	webDir := flag.String("web", "web", "Directory containing the control plane's static console page.")
+	flag.Parse()
+
+	parse, ok := durationParsers[*format]
+	if !ok && *format != "auto" {
+		exitf("unknown format %q, must be one of auto, sgenc, srt, vtt or go", *format)
+	}
+
+	ctl := newControl()
+	if *ctlAddr != "" {
+		go serveControl(*ctlAddr, ctl, *webDir)
+	}
+
 	w := csv.NewWriter(bufio.NewWriter(os.Stdout))
-	start := time.Now()
 
-	for {
-		rec, err := r.Read()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return
+	records := make(chan record)
+	go func() {
+		defer close(records)
+
+		r := csv.NewReader(bufio.NewReader(os.Stdin))
+		r.ReuseRecord = true
+
+		for {
+			rec, err := r.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return
+				}
+				exitf("unable to read records: %v", err)
 			}
-			exitf("unable to read records: %v", err)
-		}
-		d, err := parseDuration(rec[0])
-		if err != nil {
-			errorf("unable to parse record written at duration: %v", err)
-			continue
+
+			if parse == nil {
+				parse = durationParsers[detectFormat(rec[0])]
+			}
+
+			d, err := parse(rec[0])
+			if err != nil {
+				errorf("unable to parse record written at duration: %v", err)
+				continue
+			}
+
+			// r.ReuseRecord means rec's backing array is overwritten by
+			// the next Read, so it must be copied before handing it off
+			// to the emission loop, which may still be waiting on the
+			// previous record.
+			cp := make([]string, len(rec))
+			copy(cp, rec)
+			records <- record{rec: cp, at: d}
 		}
+	}()
 
-		wait := start.Add(d).Sub(time.Now())
-		<-time.After(wait)
-		if err = w.Write(rec); err != nil {
+	for rec := range records {
+		ctl.waitUntil(rec.at)
+		if err := w.Write(rec.rec); err != nil {
 			exitf("unable to write record: %v", err)
 		}
 		w.Flush()
+		ctl.recordEmitted()
 	}
 }